@@ -0,0 +1,143 @@
+// Copyright (c) 2015 Jan Broer
+// Use of this source code is governed by The MIT License (MIT) that can be
+// found in the LICENSE file.
+
+package hosts
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// hostname represents a single name/address pair. owner is empty for entries
+// parsed from a file; programmatically added entries (see Hostsfile.AddHost)
+// tag it with the caller-supplied owner so they can be retracted in bulk
+// without touching file-loaded entries.
+type hostname struct {
+	domain string
+	ip     net.IP
+	owner  string
+}
+
+// hostlist is an ordered collection of hostname entries. Order is preserved
+// so that lookups returning multiple addresses stay deterministic.
+type hostlist []hostname
+
+// newHostlist parses hosts file data in the standard /etc/hosts format: an
+// address followed by one or more whitespace separated hostnames. Comments
+// introduced by '#' and blank lines are ignored. Names using dnsmasq-style
+// wildcard/suffix syntax ("*.svc.cluster.local" or ".corp.example") are
+// returned separately rather than added to the hostlist.
+//
+// path is used only to annotate warnings for names dropped by
+// isValidHostsName; strict selects RFC-1123 LDH validation over the
+// permissive mode that rejects only structurally impossible names.
+func newHostlist(path string, data []byte, strict bool) (*hostlist, []wildcardEntry) {
+	hl := new(hostlist)
+	var wildcards []wildcardEntry
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+
+		for _, domain := range fields[1:] {
+			domain = strings.ToLower(domain)
+
+			var suffix string
+			var wildcard, matchSelf bool
+			switch {
+			case strings.HasPrefix(domain, "*."):
+				suffix = strings.TrimSuffix(domain[len("*."):], ".")
+				wildcard = true
+			case strings.HasPrefix(domain, "."):
+				suffix = strings.TrimSuffix(domain[1:], ".")
+				wildcard = true
+				matchSelf = true
+			default:
+				suffix = strings.TrimSuffix(domain, ".")
+			}
+
+			if !isValidHostsName(suffix, strict) {
+				log.Printf("go-dnsmasq: %s:%d: ignoring invalid name %q", path, lineNo, domain)
+				continue
+			}
+
+			if wildcard {
+				wildcards = append(wildcards, wildcardEntry{suffix: suffix, matchSelf: matchSelf, ip: ip})
+			} else {
+				*hl = append(*hl, hostname{domain: suffix, ip: ip})
+			}
+		}
+	}
+
+	return hl, wildcards
+}
+
+// isValidHostsName reports whether name could ever be packed into a DNS
+// response by miekg/dns: non-empty, <=255 bytes overall, each label 1-63
+// bytes, and not something ParseIP would accept as a literal address. In
+// strict mode labels are further required to be RFC-1123 LDH (letters,
+// digits, hyphens; no leading/trailing hyphen).
+func isValidHostsName(name string, strict bool) bool {
+	if name == "" || len(name) > 255 {
+		return false
+	}
+	if net.ParseIP(name) != nil {
+		return false
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		if strict && !isLDHLabel(label) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isLDHLabel reports whether label consists only of letters, digits and
+// hyphens, without a leading or trailing hyphen (RFC 1123).
+func isLDHLabel(label string) bool {
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+		default:
+			return false
+		}
+	}
+	return label[0] != '-' && label[len(label)-1] != '-'
+}
+
+// hostsFileMetadata returns the modification time and size of the hosts file
+// at path, used by watchFilesPoll to detect changes.
+func hostsFileMetadata(path string) (time.Time, int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return fi.ModTime(), fi.Size(), nil
+}
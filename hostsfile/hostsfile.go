@@ -6,55 +6,123 @@
 package hosts
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/gofrs/flock"
 	"github.com/miekg/dns"
 )
 
+// defaultPollInterval is used as the fallback poll period, in seconds, when
+// fsnotify can't be used and Config.Poll wasn't set explicitly.
+const defaultPollInterval = 20
+
 // Config stores options for hostsfile
 type Config struct {
-	// Positive value enables polling
+	// Positive value enables polling and also forces it even where fsnotify
+	// would otherwise be used (e.g. on NFS mounts where inotify is unreliable).
 	Poll    int
 	Verbose bool
+	// Writeback, when set, is the path of a sidecar file that owned entries
+	// (added via AddHost) are serialised to after every mutation, guarded by
+	// an OS file lock so multiple processes can safely append.
+	Writeback string
+	// Wildcards enables dnsmasq-style "*.domain"/".domain" wildcard and
+	// suffix matching in FindHosts.
+	Wildcards bool
+	// StrictNames enables RFC-1123 LDH validation of parsed names, in
+	// addition to the permissive checks that always apply (non-empty,
+	// length limits, and rejecting names that are really IP literals).
+	StrictNames bool
+}
+
+// fileMeta tracks the modification time and size last observed for a file,
+// used by the polling fallback to detect changes.
+type fileMeta struct {
+	mtime time.Time
+	size  int64
 }
 
-// Hostsfile represents a file containing hosts
+// Hostsfile represents one or more files containing hosts entries.
 type Hostsfile struct {
 	config *Config
 	hosts  *hostlist
-	file   struct {
-		size  int64
-		path  string
-		mtime time.Time
-	}
+	// byName and byAddr index hosts by lowercased FQDN and by the
+	// dns.ReverseAddr form of the IP respectively, so FindHosts/FindReverse
+	// are O(1) instead of scanning hosts on every query.
+	byName map[string][]net.IP
+	byAddr map[string][]string
+
+	// filePaths preserves the precedence order entries were loaded in: when
+	// the same address appears in more than one file, FindReverse resolves it
+	// to the domain from the earliest file in this list, and when the same
+	// name does, FindHosts returns the union of its addresses across all
+	// files in that same order.
+	filePaths     []string
+	fileEntries   map[string]*hostlist
+	fileWildcards map[string][]wildcardEntry
+	wildcards     *wildcardTrie
+
+	// dynamicHosts holds entries added at runtime via AddHost, independent
+	// of any file; file reloads never touch it.
+	dynamicHosts *hostlist
+	// writebackKeys remembers every "domain\tip" key this process has ever
+	// written to the writeback sidecar, even after the entry is removed
+	// from dynamicHosts, so writeback can tell "an entry we retracted" from
+	// "an entry another process owns" and purge only the former.
+	writebackKeys map[string]bool
+
 	hostMutex sync.RWMutex
 }
 
-// NewHostsfile returns a new Hostsfile object
+// NewHostsfile returns a new Hostsfile object backed by a single file.
 func NewHostsfile(path string, config *Config) (*Hostsfile, error) {
-	h := Hostsfile{config: config}
-	// when no hostfile is given we return an empty hostlist
 	if path == "" {
+		return NewHostsfiles(nil, config)
+	}
+	return NewHostsfiles([]string{path}, config)
+}
+
+// NewHostsfiles returns a new Hostsfile object that merges entries from
+// several files, e.g. /etc/hosts plus one or more container-generated
+// addnhosts files such as the ones produced by the dnsname CNI plugin.
+// Changes are picked up via fsnotify, falling back to polling when inotify
+// isn't available (NFS, non-Linux) or when Config.Poll is explicitly set.
+func NewHostsfiles(paths []string, config *Config) (*Hostsfile, error) {
+	h := Hostsfile{
+		config:        config,
+		fileEntries:   make(map[string]*hostlist),
+		fileWildcards: make(map[string][]wildcardEntry),
+		dynamicHosts:  new(hostlist),
+		writebackKeys: make(map[string]bool),
+	}
+
+	// when no hostfiles are given we return an empty hostlist
+	if len(paths) == 0 {
 		h.hosts = new(hostlist)
+		h.buildIndex()
 		return &h, nil
 	}
 
-	h.file.path = path
-	if err := h.loadHostEntries(); err != nil {
-		return nil, err
+	h.filePaths = paths
+	for _, path := range paths {
+		if err := h.reloadFile(path); err != nil {
+			return nil, err
+		}
 	}
 
-	if h.config.Poll > 0 {
-		go h.monitorHostEntries(h.config.Poll)
-	}
+	h.watchFiles()
 
 	if h.config.Verbose {
-		log.Printf("Found entries in %s:\n", h.file.path)
+		log.Printf("Found entries in %s:\n", strings.Join(h.filePaths, ", "))
 		for _, hostname := range *h.hosts {
 			log.Printf("%s %s \n",
 				hostname.domain,
@@ -66,14 +134,18 @@ func NewHostsfile(path string, config *Config) (*Hostsfile, error) {
 }
 
 func (h *Hostsfile) FindHosts(name string) (addrs []net.IP, err error) {
-	name = strings.TrimSuffix(name, ".")
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
 	h.hostMutex.RLock()
 	defer h.hostMutex.RUnlock()
 
-	for _, hostname := range *h.hosts {
-		if hostname.domain == name {
-			addrs = append(addrs, hostname.ip)
-		}
+	if ips, ok := h.byName[name]; ok {
+		addrs = append(addrs, ips...)
+		return
+	}
+
+	if h.config != nil && h.config.Wildcards && h.wildcards != nil {
+		addrs = h.wildcards.lookup(name)
 	}
 
 	return
@@ -83,62 +155,392 @@ func (h *Hostsfile) FindReverse(name string) (host string, err error) {
 	h.hostMutex.RLock()
 	defer h.hostMutex.RUnlock()
 
+	if domains, ok := h.byAddr[name]; ok && len(domains) > 0 {
+		host = dns.Fqdn(domains[0])
+	}
+
+	return
+}
+
+// buildIndex (re)builds byName and byAddr from the current hostlist. Callers
+// must hold hostMutex for writing.
+func (h *Hostsfile) buildIndex() {
+	byName := make(map[string][]net.IP)
+	byAddr := make(map[string][]string)
+
 	for _, hostname := range *h.hosts {
-		if r, _ := dns.ReverseAddr(hostname.ip.String()); name == r {
-			host = dns.Fqdn(hostname.domain)
+		byName[hostname.domain] = append(byName[hostname.domain], hostname.ip)
+
+		if r, err := dns.ReverseAddr(hostname.ip.String()); err == nil {
+			byAddr[r] = append(byAddr[r], hostname.domain)
+		}
+	}
+
+	h.byName = byName
+	h.byAddr = byAddr
+}
+
+// rebuildMerged concatenates the per-file entries, in filePaths order, into
+// the merged hostlist and rebuilds the lookup index. Callers must hold
+// hostMutex for writing.
+func (h *Hostsfile) rebuildMerged() {
+	merged := new(hostlist)
+	for _, path := range h.filePaths {
+		if hl, ok := h.fileEntries[path]; ok {
+			*merged = append(*merged, *hl...)
+		}
+	}
+	if h.dynamicHosts != nil {
+		*merged = append(*merged, *h.dynamicHosts...)
+	}
+
+	h.hosts = merged
+	h.buildIndex()
+
+	if h.config.Wildcards {
+		trie := newWildcardTrie()
+		for _, path := range h.filePaths {
+			for _, w := range h.fileWildcards[path] {
+				trie.insert(w)
+			}
+		}
+		h.wildcards = trie
+	}
+}
+
+// AddHost injects an A/AAAA record at runtime, e.g. from a container runtime
+// hook, without editing a file. owner tags the entry so it can later be
+// retracted in bulk with RemoveByOwner; pass "" if that's not needed.
+func (h *Hostsfile) AddHost(domain string, ip net.IP, owner string) error {
+	if ip == nil {
+		return fmt.Errorf("hosts: AddHost: nil IP for %q", domain)
+	}
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if !isValidHostsName(domain, h.config.StrictNames) {
+		return fmt.Errorf("hosts: AddHost: invalid name %q", domain)
+	}
+
+	h.hostMutex.Lock()
+	found := false
+	for _, hn := range *h.dynamicHosts {
+		if hn.domain == domain && hn.ip.Equal(ip) {
+			found = true
 			break
 		}
 	}
-	return
+	if !found {
+		*h.dynamicHosts = append(*h.dynamicHosts, hostname{domain: domain, ip: ip, owner: owner})
+		if owner != "" {
+			h.writebackKeys[domain+"\t"+ip.String()] = true
+		}
+		h.rebuildMerged()
+	}
+	h.hostMutex.Unlock()
+
+	return h.writeback()
 }
 
-func (h *Hostsfile) loadHostEntries() error {
-	data, err := ioutil.ReadFile(h.file.path)
+// RemoveHost retracts a single programmatically-added A/AAAA record. It is a
+// no-op for entries that only exist in a loaded file.
+func (h *Hostsfile) RemoveHost(domain string, ip net.IP) error {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	h.hostMutex.Lock()
+	kept := new(hostlist)
+	removed := false
+	for _, hn := range *h.dynamicHosts {
+		if hn.domain == domain && hn.ip.Equal(ip) {
+			removed = true
+			continue
+		}
+		*kept = append(*kept, hn)
+	}
+	h.dynamicHosts = kept
+	if removed {
+		h.rebuildMerged()
+	}
+	h.hostMutex.Unlock()
+
+	if !removed {
+		return nil
+	}
+	return h.writeback()
+}
+
+// RemoveByOwner retracts every programmatically-added entry tagged with
+// owner, returning the number of entries removed.
+func (h *Hostsfile) RemoveByOwner(owner string) int {
+	h.hostMutex.Lock()
+	kept := new(hostlist)
+	removed := 0
+	for _, hn := range *h.dynamicHosts {
+		if hn.owner == owner {
+			removed++
+			continue
+		}
+		*kept = append(*kept, hn)
+	}
+	h.dynamicHosts = kept
+	if removed > 0 {
+		h.rebuildMerged()
+	}
+	h.hostMutex.Unlock()
+
+	if removed > 0 {
+		if err := h.writeback(); err != nil {
+			log.Printf("go-dnsmasq: error writing back hosts sidecar: %s", err)
+		}
+	}
+
+	return removed
+}
+
+// writeback serialises owned dynamic entries to Config.Writeback, if set,
+// under an OS file lock so multiple processes can safely append. It reads
+// the sidecar back in first and keeps any lines this process doesn't own,
+// so a concurrent writer's entries aren't lost to a blind overwrite.
+func (h *Hostsfile) writeback() error {
+	if h.config.Writeback == "" {
+		return nil
+	}
+
+	lock := flock.New(h.config.Writeback + ".lock")
+	if err := lock.Lock(); err != nil {
+		return fmt.Errorf("hosts: locking writeback file: %w", err)
+	}
+	defer lock.Unlock()
+
+	existing, err := ioutil.ReadFile(h.config.Writeback)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("hosts: reading writeback file: %w", err)
+	}
+
+	h.hostMutex.RLock()
+	mine := make(map[string]bool, len(*h.dynamicHosts))
+	lines := make([]string, 0, len(*h.dynamicHosts))
+	for _, hn := range *h.dynamicHosts {
+		if hn.owner == "" {
+			continue
+		}
+		mine[hn.domain+"\t"+hn.ip.String()] = true
+		lines = append(lines, fmt.Sprintf("%s\t%s\t# owner=%s", hn.ip.String(), hn.domain, hn.owner))
+	}
+	managed := make(map[string]bool, len(h.writebackKeys))
+	for key := range h.writebackKeys {
+		managed[key] = true
+	}
+	h.hostMutex.RUnlock()
+
+	// Keep lines from other writers untouched. Drop ours: present ones are
+	// re-added from dynamicHosts above, and ones we've since removed (still
+	// in managed, no longer in mine) must not resurrect.
+	for _, line := range strings.Split(string(existing), "\n") {
+		ip, domain, _, ok := parseWritebackLine(line)
+		if !ok {
+			continue
+		}
+		key := domain + "\t" + ip.String()
+		if mine[key] || managed[key] {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	data := []byte(strings.Join(lines, "\n"))
+	if len(data) > 0 {
+		data = append(data, '\n')
+	}
+
+	return ioutil.WriteFile(h.config.Writeback, data, 0644)
+}
+
+// parseWritebackLine parses one line of a writeback sidecar file, in the
+// "ip\tdomain\t# owner=owner" format writeback produces.
+func parseWritebackLine(line string) (ip net.IP, domain string, owner string, ok bool) {
+	fields := strings.SplitN(strings.TrimSpace(line), "\t", 3)
+	if len(fields) < 2 {
+		return nil, "", "", false
+	}
+
+	ip = net.ParseIP(fields[0])
+	if ip == nil {
+		return nil, "", "", false
+	}
+	domain = fields[1]
+
+	if len(fields) == 3 {
+		owner = strings.TrimPrefix(strings.TrimSpace(fields[2]), "# owner=")
+	}
+
+	return ip, domain, owner, true
+}
+
+// reloadFile re-reads a single file and merges its entries back in. A
+// missing file just drops its own entries rather than failing the reload.
+func (h *Hostsfile) reloadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
+		if os.IsNotExist(err) {
+			h.hostMutex.Lock()
+			delete(h.fileEntries, path)
+			delete(h.fileWildcards, path)
+			h.rebuildMerged()
+			h.hostMutex.Unlock()
+			return nil
+		}
 		return err
 	}
 
+	hl, wildcards := newHostlist(path, data, h.config.StrictNames)
+
 	h.hostMutex.Lock()
-	h.hosts = newHostlist(data)
+	h.fileEntries[path] = hl
+	h.fileWildcards[path] = wildcards
+	h.rebuildMerged()
 	h.hostMutex.Unlock()
 
 	return nil
 }
 
-func (h *Hostsfile) monitorHostEntries(poll int) {
-	hf := h.file
-
-	if hf.path == "" {
+// watchFiles starts watching filePaths for changes, preferring fsnotify and
+// falling back to polling when inotify can't be used or Config.Poll is set.
+func (h *Hostsfile) watchFiles() {
+	if len(h.filePaths) == 0 {
 		return
 	}
 
-	t := time.Duration(poll) * time.Second
+	if h.config.Poll <= 0 {
+		if err := h.watchFilesNotify(); err == nil {
+			return
+		} else if h.config.Verbose {
+			log.Printf("go-dnsmasq: fsnotify unavailable (%s), falling back to polling", err)
+		}
+	}
 
-	for _ = range time.Tick(t) {
-		//log.Printf("go-dnsmasq: checking %q for updates…", hf.path)
+	poll := h.config.Poll
+	if poll <= 0 {
+		poll = defaultPollInterval
+	}
+	go h.watchFilesPoll(poll)
+}
 
-		mtime, size, err := hostsFileMetadata(hf.path)
-		if err != nil {
-			log.Printf("go-dnsmasq: error stating hostsfile: %s", err)
-			continue
+// watchFilesNotify watches the directories containing filePaths and reloads
+// only the file whose WRITE/CREATE/RENAME/REMOVE event fired.
+func (h *Hostsfile) watchFilesNotify() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := make(map[string]bool)
+	for _, path := range h.filePaths {
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
 		}
+	}
+
+	tracked := make(map[string]bool, len(h.filePaths))
+	for _, path := range h.filePaths {
+		tracked[filepath.Clean(path)] = true
+	}
 
-		if hf.mtime.Equal(mtime) && hf.size == size {
-			continue // no updates
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				h.handleNotifyEvent(event, tracked)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("go-dnsmasq: fsnotify error: %s", err)
+			}
 		}
+	}()
 
-		if err := h.loadHostEntries(); err != nil {
-			log.Printf("go-dnsmasq: error opening hostsfile: %s", err)
+	return nil
+}
+
+func (h *Hostsfile) handleNotifyEvent(event fsnotify.Event, tracked map[string]bool) {
+	path := filepath.Clean(event.Name)
+	if !tracked[path] {
+		return
+	}
+
+	switch {
+	case event.Op&(fsnotify.Write|fsnotify.Create) != 0:
+		if err := h.reloadFile(path); err != nil {
+			log.Printf("go-dnsmasq: error reloading hostsfile %s: %s", path, err)
+			return
+		}
+	case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		// reloadFile's os.IsNotExist branch drops both fileEntries and
+		// fileWildcards for path, so a removed file never leaves stale
+		// wildcard entries behind.
+		if err := h.reloadFile(path); err != nil {
+			log.Printf("go-dnsmasq: error reloading hostsfile %s: %s", path, err)
+			return
 		}
+	default:
+		return
+	}
+
+	if h.config.Verbose {
+		log.Printf("go-dnsmasq: reloaded changed hostsfile %s", path)
+	}
+}
 
-		if h.config.Verbose {
-			log.Printf("go-dnsmasq: reloaded changed hostsfile")
+// watchFilesPoll is the polling fallback used when fsnotify isn't available
+// or Config.Poll was set explicitly.
+func (h *Hostsfile) watchFilesPoll(poll int) {
+	metas := make(map[string]fileMeta, len(h.filePaths))
+	for _, path := range h.filePaths {
+		if mtime, size, err := hostsFileMetadata(path); err == nil {
+			metas[path] = fileMeta{mtime: mtime, size: size}
 		}
+	}
+
+	t := time.Duration(poll) * time.Second
+
+	for range time.Tick(t) {
+		for _, path := range h.filePaths {
+			mtime, size, err := hostsFileMetadata(path)
+			if err != nil {
+				if os.IsNotExist(err) {
+					if _, ok := metas[path]; ok {
+						delete(metas, path)
+						if rerr := h.reloadFile(path); rerr != nil {
+							log.Printf("go-dnsmasq: error dropping missing hostsfile %s: %s", path, rerr)
+						}
+					}
+				} else {
+					log.Printf("go-dnsmasq: error stating hostsfile %s: %s", path, err)
+				}
+				continue
+			}
 
-		h.hostMutex.Lock()
-		h.file.mtime = mtime
-		h.file.size = size
-		hf = h.file
-		h.hostMutex.Unlock()
+			prev, seen := metas[path]
+			if seen && prev.mtime.Equal(mtime) && prev.size == size {
+				continue // no updates
+			}
+
+			if err := h.reloadFile(path); err != nil {
+				log.Printf("go-dnsmasq: error opening hostsfile %s: %s", path, err)
+				continue
+			}
+			metas[path] = fileMeta{mtime: mtime, size: size}
+
+			if h.config.Verbose {
+				log.Printf("go-dnsmasq: reloaded changed hostsfile %s", path)
+			}
+		}
 	}
-}
\ No newline at end of file
+}
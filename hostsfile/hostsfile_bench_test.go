@@ -0,0 +1,60 @@
+// Copyright (c) 2015 Jan Broer
+// Use of this source code is governed by The MIT License (MIT) that can be
+// found in the LICENSE file.
+
+package hosts
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func buildBenchHostsfile(n int) *Hostsfile {
+	hl := make(hostlist, 0, n)
+	for i := 0; i < n; i++ {
+		hl = append(hl, hostname{
+			domain: fmt.Sprintf("host%d.example.com", i),
+			ip:     net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)),
+		})
+	}
+
+	h := &Hostsfile{hosts: &hl}
+	h.buildIndex()
+	return h
+}
+
+// findHostsScan reproduces the pre-index linear scan. It is kept here only
+// so the benchmark below can show the win the byName/byAddr maps provide.
+func findHostsScan(h *Hostsfile, name string) []net.IP {
+	name = strings.TrimSuffix(name, ".")
+
+	var addrs []net.IP
+	for _, hn := range *h.hosts {
+		if hn.domain == name {
+			addrs = append(addrs, hn.ip)
+		}
+	}
+	return addrs
+}
+
+func BenchmarkFindHostsScan(b *testing.B) {
+	h := buildBenchHostsfile(10000)
+	name := "host9999.example.com"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		findHostsScan(h, name)
+	}
+}
+
+func BenchmarkFindHostsIndexed(b *testing.B) {
+	h := buildBenchHostsfile(10000)
+	name := "host9999.example.com"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.FindHosts(name)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2015 Jan Broer
+// Use of this source code is governed by The MIT License (MIT) that can be
+// found in the LICENSE file.
+
+package hosts
+
+import (
+	"net"
+	"strings"
+)
+
+// wildcardEntry is a parsed dnsmasq-style wildcard/suffix hosts line, e.g.
+// "10.0.0.1 *.svc.cluster.local" or "10.1.2.3 .corp.example".
+type wildcardEntry struct {
+	// suffix is the domain with its leading "*." or "." stripped.
+	suffix string
+	// matchSelf is true for the ".corp.example" form, where the bare suffix
+	// itself (not just names below it) also matches.
+	matchSelf bool
+	ip        net.IP
+}
+
+// wildcardNode is one label of a reversed-label trie: children are keyed by
+// the next label walking from the TLD towards the leftmost label.
+type wildcardNode struct {
+	children  map[string]*wildcardNode
+	ips       []net.IP
+	matchSelf bool
+}
+
+// wildcardTrie resolves a query name to the longest matching wildcard
+// suffix, mirroring dnsmasq's address=/domain/ip precedence.
+type wildcardTrie struct {
+	root *wildcardNode
+}
+
+func newWildcardTrie() *wildcardTrie {
+	return &wildcardTrie{root: &wildcardNode{children: make(map[string]*wildcardNode)}}
+}
+
+// reversedLabels splits a FQDN into its labels ordered from the TLD down to
+// the leftmost label, e.g. "a.b.example.com" -> ["com","example","b","a"].
+func reversedLabels(name string) []string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return nil
+	}
+
+	labels := strings.Split(name, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return labels
+}
+
+func (t *wildcardTrie) insert(e wildcardEntry) {
+	node := t.root
+	for _, label := range reversedLabels(e.suffix) {
+		child, ok := node.children[label]
+		if !ok {
+			child = &wildcardNode{children: make(map[string]*wildcardNode)}
+			node.children[label] = child
+		}
+		node = child
+	}
+
+	node.ips = append(node.ips, e.ip)
+	if e.matchSelf {
+		node.matchSelf = true
+	}
+}
+
+// lookup walks name's labels from the TLD down, returning the IPs of the
+// longest matching wildcard suffix, or nil if none match. A "*.suffix"
+// pattern only matches names with at least one label below suffix; a
+// ".suffix" pattern also matches suffix itself. The returned slice is a
+// copy, so callers are free to mutate it like the exact-match path allows.
+func (t *wildcardTrie) lookup(name string) []net.IP {
+	labels := reversedLabels(name)
+
+	node := t.root
+	var best []net.IP
+	for i, label := range labels {
+		child, ok := node.children[label]
+		if !ok {
+			break
+		}
+		node = child
+
+		if len(node.ips) == 0 {
+			continue
+		}
+
+		remaining := len(labels) - i - 1
+		if remaining > 0 || node.matchSelf {
+			best = node.ips
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+	return append([]net.IP(nil), best...)
+}
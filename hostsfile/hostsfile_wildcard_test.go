@@ -0,0 +1,87 @@
+// Copyright (c) 2015 Jan Broer
+// Use of this source code is governed by The MIT License (MIT) that can be
+// found in the LICENSE file.
+
+package hosts
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func newWildcardTestHostsfile(t *testing.T, data string) *Hostsfile {
+	t.Helper()
+
+	hl, wildcards := newHostlist("test", []byte(data), false)
+	trie := newWildcardTrie()
+	for _, w := range wildcards {
+		trie.insert(w)
+	}
+
+	h := &Hostsfile{config: &Config{Wildcards: true}, hosts: hl, wildcards: trie}
+	h.buildIndex()
+	return h
+}
+
+func TestFindHostsWildcardSuffixMatch(t *testing.T) {
+	h := newWildcardTestHostsfile(t, "10.0.0.1 *.svc.cluster.local\n")
+
+	addrs, _ := h.FindHosts("api.svc.cluster.local")
+	if len(addrs) != 1 || !addrs[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("expected 10.0.0.1, got %v", addrs)
+	}
+
+	// trailing-dot normalisation
+	addrs, _ = h.FindHosts("api.svc.cluster.local.")
+	if len(addrs) != 1 || !addrs[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("expected 10.0.0.1 with trailing dot, got %v", addrs)
+	}
+
+	// "*.x" requires at least one label below x
+	if addrs, _ := h.FindHosts("svc.cluster.local"); len(addrs) != 0 {
+		t.Fatalf("expected no match for bare domain, got %v", addrs)
+	}
+}
+
+func TestFindHostsDotSuffixMatchesSelf(t *testing.T) {
+	h := newWildcardTestHostsfile(t, "10.1.2.3 .corp.example\n")
+
+	addrs, _ := h.FindHosts("corp.example")
+	if len(addrs) != 1 || !addrs[0].Equal(net.ParseIP("10.1.2.3")) {
+		t.Fatalf("expected .corp.example to match itself, got %v", addrs)
+	}
+
+	addrs, _ = h.FindHosts("host.corp.example")
+	if len(addrs) != 1 || !addrs[0].Equal(net.ParseIP("10.1.2.3")) {
+		t.Fatalf("expected host.corp.example to match, got %v", addrs)
+	}
+}
+
+func TestFindHostsExactBeatsWildcard(t *testing.T) {
+	h := newWildcardTestHostsfile(t, "10.0.0.1 *.svc.cluster.local\n10.0.0.2 api.svc.cluster.local\n")
+
+	addrs, _ := h.FindHosts("api.svc.cluster.local")
+	if len(addrs) != 1 || !addrs[0].Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("expected exact match 10.0.0.2, got %v", addrs)
+	}
+}
+
+func TestFindHostsLongestSuffixWins(t *testing.T) {
+	h := newWildcardTestHostsfile(t, "10.0.0.1 *.cluster.local\n10.0.0.2 *.svc.cluster.local\n")
+
+	addrs, _ := h.FindHosts("api.svc.cluster.local")
+	if len(addrs) != 1 || !addrs[0].Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("expected longest suffix match 10.0.0.2, got %v", addrs)
+	}
+}
+
+func TestFindReverseSkipsWildcards(t *testing.T) {
+	h := newWildcardTestHostsfile(t, "10.0.0.1 *.svc.cluster.local\n")
+
+	r, _ := dns.ReverseAddr("10.0.0.1")
+	if host, _ := h.FindReverse(r); host != "" {
+		t.Fatalf("expected reverse lookup to skip wildcard entries, got %q", host)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2015 Jan Broer
+// Use of this source code is governed by The MIT License (MIT) that can be
+// found in the LICENSE file.
+
+package hosts
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWritebackPurgesRemovedOwner(t *testing.T) {
+	sidecar := filepath.Join(t.TempDir(), "owned.hosts")
+
+	h, err := NewHostsfiles(nil, &Config{Writeback: sidecar})
+	if err != nil {
+		t.Fatalf("NewHostsfiles: %v", err)
+	}
+
+	if err := h.AddHost("a.example", net.ParseIP("10.0.0.1"), "owner1"); err != nil {
+		t.Fatalf("AddHost: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(sidecar)
+	if err != nil {
+		t.Fatalf("reading sidecar: %v", err)
+	}
+	if !strings.Contains(string(data), "a.example") {
+		t.Fatalf("expected sidecar to contain a.example after AddHost, got %q", data)
+	}
+
+	if n := h.RemoveByOwner("owner1"); n != 1 {
+		t.Fatalf("expected RemoveByOwner to remove 1 entry, got %d", n)
+	}
+
+	data, err = ioutil.ReadFile(sidecar)
+	if err != nil {
+		t.Fatalf("reading sidecar after removal: %v", err)
+	}
+	if strings.Contains(string(data), "a.example") {
+		t.Fatalf("expected sidecar to no longer contain a.example after RemoveByOwner, got %q", data)
+	}
+}